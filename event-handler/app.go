@@ -18,6 +18,9 @@ package main
 
 import (
 	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gravitational/teleport-plugins/lib"
@@ -25,6 +28,7 @@ import (
 	"github.com/gravitational/teleport-plugins/lib/logger"
 	"github.com/gravitational/trace"
 	"github.com/jonboulle/clockwork"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 )
@@ -43,8 +47,10 @@ type App struct {
 	// mainJob is the main poller loop
 	mainJob lib.ServiceJob
 
-	// fluentd is an instance of Fluentd client
-	fluentd *FluentdClient
+	// sinks are the configured event destinations, fanned out to in
+	// parallel by sendEvent. The fluentd sink is always present; webhook
+	// and file sinks are added per a.config.Sinks.
+	sinks []EventSink
 
 	// teleport is an instance of Teleport client
 	teleport *TeleportEventsWatcher
@@ -64,6 +70,51 @@ type App struct {
 	// sessionConsumerJob controls session ingestion
 	sessionConsumerJob lib.ServiceJob
 
+	// activeSessionsMu guards activeSessions
+	activeSessionsMu sync.Mutex
+	// activeSessions holds the IDs of sessions currently held by a
+	// runSessionConsumer retry loop, from the moment they're picked up off
+	// a.sessions until consumeSession stops retrying them. Scoping
+	// scanForMissingRecordings to exclude these keeps it from racing a
+	// genuine in-flight consumeSession for the same ID.
+	activeSessions map[string]struct{}
+
+	// processMissingRecordingsJob periodically detects sessions whose
+	// recording was never uploaded or has since been purged
+	processMissingRecordingsJob lib.ServiceJob
+
+	// windowMu guards windowTime
+	windowMu sync.Mutex
+	// windowTime is the timestamp of the most recent event successfully
+	// delivered from the main poll loop, used as the sliding window
+	// checkpoint on restart
+	windowTime time.Time
+
+	// stopAccepting is closed to tell runSessionConsumer to stop pulling
+	// new work off sessions, as part of a graceful SIGHUP reload or a
+	// SIGUSR2 live upgrade handoff
+	stopAccepting chan struct{}
+	// stopOnce guards stopAccepting against being closed twice
+	stopOnce sync.Once
+
+	// metricsJob serves the Prometheus /metrics and /healthz endpoints
+	metricsJob lib.ServiceJob
+	// registry holds this App's Prometheus collectors
+	registry *prometheus.Registry
+	// metrics are the collectors registered against registry
+	metrics *appMetrics
+	// metricsServerMu guards metricsServer
+	metricsServerMu sync.Mutex
+	// metricsServer is the running /metrics and /healthz HTTP server, nil
+	// until runMetricsServer has bound it
+	metricsServer *http.Server
+	// lastSendUnixNano is the UnixNano timestamp of the last successfully
+	// delivered event, read and written atomically
+	lastSendUnixNano int64
+	// mainJobReady and sessionConsumerReady mirror the corresponding
+	// job's ready state for the /healthz handler
+	mainJobReady, sessionConsumerReady int32
+
 	// Process
 	*lib.Process
 }
@@ -75,6 +126,36 @@ const (
 	sessionBackoffMax = 2 * time.Minute
 	// sessionBackoffNumTries is the maximum number of backoff tries
 	sessionBackoffNumTries = 5
+	// missingRecordingsPollInterval is how often we scan pending sessions
+	// for recordings that will never arrive
+	missingRecordingsPollInterval = 5 * time.Minute
+	// missingRecordingEventType is the synthetic event type emitted to
+	// fluentd when a session's recording could not be found
+	missingRecordingEventType = "session.recording.missing"
+	// windowCheckpointEventCount is how many successfully-sent events we
+	// allow before persisting a new window start time
+	windowCheckpointEventCount = 1000
+	// windowCheckpointFlushInterval is the maximum time between window
+	// start time checkpoints, regardless of event volume
+	windowCheckpointFlushInterval = 1 * time.Minute
+	// windowOverlap is subtracted from the persisted window start time on
+	// resume so we do not miss events that arrived concurrently with the
+	// last checkpoint
+	windowOverlap = 5 * time.Minute
+	// sinkBackoffBase is an initial (minimum) backoff value for per-sink
+	// delivery retries
+	sinkBackoffBase = 1 * time.Second
+	// sinkBackoffMax is a backoff threshold for per-sink delivery retries
+	sinkBackoffMax = 30 * time.Second
+	// sinkBackoffNumTries is the maximum number of delivery retries for a
+	// single sink before its failure is treated as permanent for this event
+	sinkBackoffNumTries = 3
+	// pollBackoffBase is the initial (minimum) reconnect backoff for the
+	// main audit log poll loop
+	pollBackoffBase = 1 * time.Second
+	// pollBackoffMax is the reconnect backoff ceiling for the main audit
+	// log poll loop
+	pollBackoffMax = 60 * time.Second
 )
 
 // NewApp creates new app instance
@@ -82,8 +163,14 @@ func NewApp(c *StartCmdConfig) (*App, error) {
 	app := &App{config: c}
 	app.mainJob = lib.NewServiceJob(app.run)
 	app.sessionConsumerJob = lib.NewServiceJob(app.runSessionConsumer)
+	app.processMissingRecordingsJob = lib.NewServiceJob(app.processMissingRecordings)
+	app.metricsJob = lib.NewServiceJob(app.runMetricsServer)
 	app.semaphore = make(chan struct{}, c.Concurrency)
 	app.sessions = make(chan session)
+	app.stopAccepting = make(chan struct{})
+	app.activeSessions = make(map[string]struct{})
+	app.registry = prometheus.NewRegistry()
+	app.metrics = newAppMetrics(app.registry)
 
 	return app, nil
 }
@@ -94,14 +181,41 @@ func (a *App) Run(ctx context.Context) error {
 
 	a.SpawnCriticalJob(a.mainJob)
 	a.SpawnCriticalJob(a.sessionConsumerJob)
+	a.SpawnCriticalJob(a.processMissingRecordingsJob)
+	if a.config.MetricsAddr != "" {
+		a.SpawnCriticalJob(a.metricsJob)
+	}
+	go a.handleSignals(ctx)
 	<-a.Process.Done()
 
+	a.flushForShutdown(ctx)
+
 	return a.Err()
 }
 
+// setWindowTime records the timestamp of the most recently delivered event
+func (a *App) setWindowTime(t time.Time) {
+	a.windowMu.Lock()
+	defer a.windowMu.Unlock()
+	a.windowTime = t
+}
+
+// flushWindowTime persists the current window checkpoint to State
+func (a *App) flushWindowTime() error {
+	a.windowMu.Lock()
+	t := a.windowTime
+	a.windowMu.Unlock()
+
+	if t.IsZero() {
+		return nil
+	}
+
+	return trace.Wrap(a.state.SetLastWindowTime(&t))
+}
+
 // Err returns the error app finished with.
 func (a *App) Err() error {
-	return trace.NewAggregate(a.mainJob.Err(), a.sessionConsumerJob.Err())
+	return trace.NewAggregate(a.mainJob.Err(), a.sessionConsumerJob.Err(), a.processMissingRecordingsJob.Err(), a.metricsJob.Err())
 }
 
 // WaitReady waits for http and watcher service to start up.
@@ -116,16 +230,67 @@ func (a *App) WaitReady(ctx context.Context) (bool, error) {
 		return false, trace.Wrap(err)
 	}
 
-	return mainReady && sessionConsumerReady, nil
+	missingRecordingsReady, err := a.processMissingRecordingsJob.WaitReady(ctx)
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+
+	metricsReady := true
+	if a.config.MetricsAddr != "" {
+		metricsReady, err = a.metricsJob.WaitReady(ctx)
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+	}
+
+	return mainReady && sessionConsumerReady && missingRecordingsReady && metricsReady, nil
+}
+
+// sessionStreamOutcome classifies how consumeSession should react to an
+// error read off a session's chErr channel
+type sessionStreamOutcome int
+
+const (
+	// sessionStreamPermanent is any failure that isn't worth retrying or
+	// treating as a missing recording (e.g. a malformed stream)
+	sessionStreamPermanent sessionStreamOutcome = iota
+	// sessionStreamRetryable is a connection problem, worth retrying with
+	// the session backoff
+	sessionStreamRetryable
+	// sessionStreamMissingRecording means the recording was never
+	// uploaded or has since been purged by retention policy
+	sessionStreamMissingRecording
+)
+
+// classifySessionStreamError decides whether a StreamSessionEvents error
+// means the recording is missing, is worth retrying, or is a permanent
+// failure for this session.
+func classifySessionStreamError(err error) sessionStreamOutcome {
+	switch {
+	case trace.IsNotFound(err):
+		return sessionStreamMissingRecording
+	case trace.IsConnectionProblem(err):
+		return sessionStreamRetryable
+	default:
+		return sessionStreamPermanent
+	}
 }
 
 // consumeSession ingests session
 func (a *App) consumeSession(ctx context.Context, s session) (bool, error) {
 	log := logger.Get(ctx)
 
-	url := a.config.FluentdSessionURL + "." + s.ID + ".log"
 	ctx = a.contextWithCancelOnTerminate(ctx)
 
+	missing, err := a.state.IsMissingRecording(s.ID)
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	if missing {
+		log.WithField("id", s.ID).Debug("Session recording previously marked missing, skipping")
+		return false, a.state.RemoveSession(s.ID)
+	}
+
 	log.WithField("id", s.ID).WithField("index", s.Index).Info("Started session events ingest")
 	chEvt, chErr := a.teleport.StreamSessionEvents(ctx, s.ID, s.Index)
 
@@ -133,7 +298,15 @@ Loop:
 	for {
 		select {
 		case err := <-chErr:
-			return true, trace.Wrap(err)
+			if classifySessionStreamError(err) == sessionStreamMissingRecording {
+				log.WithField("id", s.ID).Warning("Session recording not found, skipping")
+				return false, trace.Wrap(a.markRecordingMissing(ctx, s.ID))
+			}
+
+			// Only connection problems are worth retrying. Anything else
+			// (e.g. a malformed stream) is a permanent failure for this
+			// session.
+			return classifySessionStreamError(err) == sessionStreamRetryable, trace.Wrap(err)
 
 		case evt := <-chEvt:
 			if evt == nil {
@@ -146,16 +319,15 @@ Loop:
 				return false, trace.Wrap(err)
 			}
 
-			_, ok := a.config.SkipSessionTypes[e.Type]
-			if !ok {
-				err := a.sendEvent(ctx, url, &e)
-
-				if err != nil && trace.IsConnectionProblem(err) {
-					return true, trace.Wrap(err)
-				}
-				if err != nil {
-					return false, trace.Wrap(err)
-				}
+			// Filtering by event type is now a per-sink decision (see
+			// FluentdSink/WebhookSink/FileSink.Send), so every sink gets a
+			// chance to subscribe to event categories the others skip.
+			err = a.sendEvent(ctx, "session", &e)
+			if err != nil && trace.IsConnectionProblem(err) {
+				return true, trace.Wrap(err)
+			}
+			if err != nil {
+				return false, trace.Wrap(err)
 			}
 
 			// Set session index
@@ -186,19 +358,25 @@ func (a *App) runSessionConsumer(ctx context.Context) error {
 	log := logger.Get(ctx)
 
 	a.sessionConsumerJob.SetReady(true)
+	atomic.StoreInt32(&a.sessionConsumerReady, 1)
 
 	ctx = a.contextWithCancelOnTerminate(ctx)
 
 	for {
 		select {
+		case <-a.stopAccepting:
+			log.Info("Session consumer draining, no longer accepting new sessions")
+			return nil
 		case s := <-a.sessions:
 			a.takeSemaphore(ctx)
+			a.markSessionActive(s.ID)
 
 			log.WithField("id", s.ID).WithField("index", s.Index).Info("Starting session ingest")
 
 			func(s session) {
 				a.SpawnCritical(func(ctx context.Context) error {
 					defer a.releaseSemaphore(ctx)
+					defer a.markSessionInactive(s.ID)
 
 					backoff := backoff.NewDecorr(sessionBackoffBase, sessionBackoffMax, clockwork.NewRealClock())
 					backoffCount := sessionBackoffNumTries
@@ -258,25 +436,50 @@ func (a *App) run(ctx context.Context) error {
 		return trace.Wrap(err)
 	}
 
+	if err := a.waitForUpgradeHandoff(ctx); err != nil {
+		return trace.Wrap(err)
+	}
+
 	a.restartPausedSessions()
 
 	a.mainJob.SetReady(true)
+	atomic.StoreInt32(&a.mainJobReady, 1)
 
 	ctx = a.contextWithCancelOnTerminate(ctx)
 
+	pollBackoff := backoff.NewDecorr(pollBackoffBase, pollBackoffMax, clockwork.NewRealClock())
+	pollBackoffTier := 0
+
+	resetPollBackoff := func() {
+		// Recreate the backoff object itself, not just the logging tier,
+		// so the decorrelated-jitter sleep duration actually drops back to
+		// base rather than continuing to climb from its last value.
+		pollBackoff = backoff.NewDecorr(pollBackoffBase, pollBackoffMax, clockwork.NewRealClock())
+		pollBackoffTier = 0
+	}
+
 	for {
-		err := a.poll(ctx)
+		err := a.poll(ctx, resetPollBackoff)
 
 		switch {
 		case trace.IsConnectionProblem(err):
-			log.WithError(err).Error("Failed to connect to Teleport Auth server. Reconnecting...")
+			pollBackoffTier++
+			log.WithError(err).WithField("backoff_tier", pollBackoffTier).Error("Failed to connect to Teleport Auth server. Reconnecting...")
+			if err := pollBackoff.Do(ctx); err != nil {
+				return trace.Wrap(err)
+			}
 		case trace.IsEOF(err):
-			log.WithError(err).Error("Watcher stream closed. Reconnecting...")
+			pollBackoffTier++
+			log.WithError(err).WithField("backoff_tier", pollBackoffTier).Error("Watcher stream closed. Reconnecting...")
+			if err := pollBackoff.Do(ctx); err != nil {
+				return trace.Wrap(err)
+			}
 		case lib.IsCanceled(err):
 			log.Debug("Watcher context is cancelled")
 			a.Terminate()
 			return nil
 		default:
+			resetPollBackoff()
 			a.Terminate()
 			if err == nil {
 				return nil
@@ -324,6 +527,96 @@ func (a *App) restartPausedSessions() error {
 	return nil
 }
 
+// markRecordingMissing records the session as having no recording, emits a
+// synthetic session.recording.missing event and removes the session from
+// the pending queue so it is not retried again.
+func (a *App) markRecordingMissing(ctx context.Context, sessionID string) error {
+	if err := a.state.AddMissingRecording(sessionID); err != nil {
+		return trace.Wrap(err)
+	}
+
+	if err := a.emitMissingRecordingEvent(ctx, sessionID); err != nil {
+		return trace.Wrap(err)
+	}
+
+	return trace.Wrap(a.state.RemoveSession(sessionID))
+}
+
+// emitMissingRecordingEvent sends a synthetic event to fluentd so that
+// downstream consumers can tell a session was skipped rather than silently
+// dropped.
+func (a *App) emitMissingRecordingEvent(ctx context.Context, sessionID string) error {
+	e := NewMissingRecordingEvent(sessionID)
+
+	return trace.Wrap(a.sendEvent(ctx, "session", e))
+}
+
+// processMissingRecordings periodically scans pending sessions for
+// recordings that were never uploaded or have since been purged by
+// retention policy, so consumeSession does not burn through its retry
+// budget on sessions that will never produce a recording.
+func (a *App) processMissingRecordings(ctx context.Context) error {
+	a.processMissingRecordingsJob.SetReady(true)
+
+	ctx = a.contextWithCancelOnTerminate(ctx)
+
+	ticker := time.NewTicker(missingRecordingsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.scanForMissingRecordings(ctx); err != nil {
+				logger.Get(ctx).WithField("err", err).Error("Failed to scan for missing recordings")
+			}
+		case <-ctx.Done():
+			if lib.IsCanceled(ctx.Err()) {
+				return nil
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// scanForMissingRecordings checks every pending, not-currently-ingesting
+// session's recording status with Teleport and marks ones that return
+// NotFound as missing, so they are removed from the pending queue ahead of
+// the next consumeSession attempt. Sessions a consumeSession retry loop is
+// actively streaming are skipped: their recording can legitimately still be
+// uploading, and marking one missing out from under its live consumer would
+// remove state and emit a synthetic "missing" event while genuine events
+// for the same session are still arriving.
+func (a *App) scanForMissingRecordings(ctx context.Context) error {
+	log := logger.Get(ctx)
+
+	sessions, err := a.state.GetSessions()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	for id := range sessions {
+		if a.isSessionActive(id) {
+			continue
+		}
+
+		err := a.teleport.CheckSessionRecordingExists(ctx, id)
+		if err == nil {
+			continue
+		}
+
+		if !trace.IsNotFound(err) {
+			log.WithField("id", id).WithField("err", err).Error("Failed to check session recording status")
+			continue
+		}
+
+		if err := a.markRecordingMissing(ctx, id); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
 // startSessionPoll starts session event ingestion
 func (a *App) startSessionPoll(ctx context.Context, e *TeleportEvent) error {
 	err := a.state.SetSessionIndex(e.SessionID, 0)
@@ -341,13 +634,21 @@ func (a *App) startSessionPoll(ctx context.Context, e *TeleportEvent) error {
 	}
 }
 
-// poll polls main audit log
-func (a *App) poll(ctx context.Context) error {
+// poll polls main audit log. onProgress is invoked once, the first time an
+// event is successfully delivered in this poll cycle, so the caller can
+// reset its reconnect backoff now that the connection has proven healthy.
+func (a *App) poll(ctx context.Context, onProgress func()) error {
 	evtCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	chEvt, chErr := a.teleport.Events(evtCtx)
 
+	checkpointTicker := time.NewTicker(windowCheckpointFlushInterval)
+	defer checkpointTicker.Stop()
+
+	eventsSinceCheckpoint := 0
+	progressReported := false
+
 	for {
 		select {
 		case err := <-chErr:
@@ -359,13 +660,27 @@ func (a *App) poll(ctx context.Context) error {
 				return nil
 			}
 
-			err := a.sendEvent(ctx, a.config.FluentdURL, evt)
+			err := a.sendEvent(ctx, "audit", evt)
 			if err != nil {
 				return trace.Wrap(err)
 			}
 
+			if !progressReported {
+				onProgress()
+				progressReported = true
+			}
+
 			a.state.SetID(evt.ID)
 			a.state.SetCursor(evt.Cursor)
+			a.setWindowTime(evt.Time)
+
+			eventsSinceCheckpoint++
+			if eventsSinceCheckpoint >= windowCheckpointEventCount {
+				if err := a.flushWindowTime(); err != nil {
+					return trace.Wrap(err)
+				}
+				eventsSinceCheckpoint = 0
+			}
 
 			if evt.IsSessionEnd {
 				func(evt *TeleportEvent) {
@@ -374,21 +689,58 @@ func (a *App) poll(ctx context.Context) error {
 					})
 				}(evt)
 			}
+		case <-checkpointTicker.C:
+			if err := a.flushWindowTime(); err != nil {
+				return trace.Wrap(err)
+			}
+			eventsSinceCheckpoint = 0
 		case <-ctx.Done():
 			return ctx.Err()
 		}
 	}
 }
 
-// sendEvent sends an event to fluentd
-func (a *App) sendEvent(ctx context.Context, url string, e *TeleportEvent) error {
+// sendEvent fans an event out to every configured sink concurrently and
+// waits for all of them. Only the always-present fluentd sink (a.sinks[0])
+// is required, and only its error is returned; errors are not aggregated
+// across sinks. A connection problem there is returned as a connection
+// problem, so callers keep retrying the event as a whole, and any other
+// failure there is treated as permanent. The additional
+// sinks configured via a.config.Sinks are best-effort — a failure there is
+// logged and dropped so a misconfigured webhook or a full disk can't take
+// down audit-log delivery or session ingestion.
+func (a *App) sendEvent(ctx context.Context, category string, e *TeleportEvent) error {
 	log := logger.Get(ctx)
 
 	if !a.config.DryRun {
-		err := a.fluentd.Send(ctx, url, e.Event)
-		if err != nil {
+		errs := make([]error, len(a.sinks))
+
+		var wg sync.WaitGroup
+		for i, sink := range a.sinks {
+			wg.Add(1)
+			go func(i int, sink EventSink) {
+				defer wg.Done()
+				errs[i] = a.sendToSink(ctx, sink, category, e)
+			}(i, sink)
+		}
+		wg.Wait()
+
+		for i, err := range errs[1:] {
+			if err == nil {
+				continue
+			}
+			log.WithFields(logrus.Fields{"sink": sinkName(a.sinks[i+1]), "err": err}).
+				Error("Optional sink failed to deliver event, dropping for this sink")
+		}
+
+		if err := errs[0]; err != nil {
+			if trace.IsConnectionProblem(err) {
+				return trace.ConnectionProblem(err, "failed to deliver event to required sink")
+			}
 			return trace.Wrap(err)
 		}
+
+		a.recordSendSuccess(e)
 	}
 
 	fields := logrus.Fields{"id": e.ID, "type": e.Type, "ts": e.Time, "index": e.Index}
@@ -402,6 +754,33 @@ func (a *App) sendEvent(ctx context.Context, url string, e *TeleportEvent) error
 	return nil
 }
 
+// sendToSink delivers an event to a single sink, retrying connection
+// problems with a decorrelated-jitter backoff before giving up.
+func (a *App) sendToSink(ctx context.Context, sink EventSink, category string, e *TeleportEvent) error {
+	b := backoff.NewDecorr(sinkBackoffBase, sinkBackoffMax, clockwork.NewRealClock())
+
+	var err error
+	for i := 0; i <= sinkBackoffNumTries; i++ {
+		start := time.Now()
+		err = sink.Send(ctx, category, e)
+		a.recordSinkSend(sink, e, time.Since(start), err)
+		if err == nil {
+			return nil
+		}
+		if !trace.IsConnectionProblem(err) {
+			return trace.Wrap(err)
+		}
+		if i == sinkBackoffNumTries {
+			break
+		}
+		if backoffErr := b.Do(ctx); backoffErr != nil {
+			return trace.Wrap(backoffErr)
+		}
+	}
+
+	return trace.Wrap(err)
+}
+
 // init initializes application state
 func (a *App) init(ctx context.Context) error {
 	log := logger.Get(ctx)
@@ -423,6 +802,11 @@ func (a *App) init(ctx context.Context) error {
 		return trace.Wrap(err)
 	}
 
+	sinks, err := a.buildSinks(f)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
 	latestCursor, err := s.GetCursor()
 	if err != nil {
 		return trace.Wrap(err)
@@ -438,22 +822,54 @@ func (a *App) init(ctx context.Context) error {
 		return trace.Wrap(err)
 	}
 
-	t, err := NewTeleportEventsWatcher(ctx, a.config, *startTime, latestCursor, latestID)
+	windowStartTime, err := a.resumeWindowTime(s, *startTime)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	t, err := NewTeleportEventsWatcher(ctx, a.config, windowStartTime, latestCursor, latestID)
 	if err != nil {
 		return trace.Wrap(err)
 	}
 
 	a.state = s
-	a.fluentd = f
+	a.sinks = sinks
 	a.teleport = t
 
 	log.WithField("cursor", latestCursor).Info("Using initial cursor value")
 	log.WithField("id", latestID).Info("Using initial ID value")
 	log.WithField("value", startTime).Info("Using start time from state")
+	log.WithField("value", windowStartTime).Info("Resuming ingestion window from")
 
 	return nil
 }
 
+// buildSinks assembles the configured EventSink fan-out set. The fluentd
+// sink is always present for backwards compatibility; additional sinks are
+// configured via a.config.Sinks.
+func (a *App) buildSinks(f *FluentdClient) ([]EventSink, error) {
+	sinks := []EventSink{
+		NewFluentdSink(f, a.config.FluentdURL, a.config.FluentdSessionURL, a.config.SkipSessionTypes),
+	}
+
+	for _, sc := range a.config.Sinks {
+		switch sc.Type {
+		case SinkTypeWebhook:
+			sinks = append(sinks, NewWebhookSink(sc.URL, []byte(sc.HMACSecret), sc.SkipTypes))
+		case SinkTypeFile:
+			fileSink, err := NewFileSink(sc.Dir, sc.MaxBytes, sc.SkipTypes)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			sinks = append(sinks, fileSink)
+		default:
+			return nil, trace.BadParameter("unknown sink type %q", sc.Type)
+		}
+	}
+
+	return sinks, nil
+}
+
 // setStartTime sets start time or fails if start time has changed from the last run
 func (a *App) setStartTime(ctx context.Context, s *State) error {
 	log := logger.Get(ctx)
@@ -484,6 +900,36 @@ func (a *App) setStartTime(ctx context.Context, s *State) error {
 	return nil
 }
 
+// resumeWindowTime returns the point in time ingestion should resume from:
+// the last persisted window start (minus an overlap, to tolerate events
+// in flight at the last checkpoint), or the configured start time if no
+// window checkpoint has been persisted yet or it predates it.
+func (a *App) resumeWindowTime(s *State, configuredStartTime time.Time) (time.Time, error) {
+	lastWindowTime, err := s.GetLastWindowTime()
+	if err != nil {
+		return time.Time{}, trace.Wrap(err)
+	}
+
+	return computeResumeWindowTime(lastWindowTime, configuredStartTime), nil
+}
+
+// computeResumeWindowTime applies the overlap/clamping rule on its own, so
+// it can be tested without a real State: resume windowOverlap before the
+// last checkpoint, but never before configuredStartTime, and never before
+// any checkpoint has been persisted.
+func computeResumeWindowTime(lastWindowTime *time.Time, configuredStartTime time.Time) time.Time {
+	if lastWindowTime == nil {
+		return configuredStartTime
+	}
+
+	resumeFrom := lastWindowTime.Add(-windowOverlap)
+	if resumeFrom.Before(configuredStartTime) {
+		return configuredStartTime
+	}
+
+	return resumeFrom
+}
+
 // contextWithCancelOnTerminate creates child context which is canceled when app receives onTerminate signal (graceful shutdown)
 func (a *App) contextWithCancelOnTerminate(ctx context.Context) context.Context {
 	process := lib.MustGetProcess(ctx)
@@ -514,3 +960,28 @@ func (a *App) releaseSemaphore(ctx context.Context) error {
 		return ctx.Err()
 	}
 }
+
+// markSessionActive records that id is now held by a runSessionConsumer
+// retry loop
+func (a *App) markSessionActive(id string) {
+	a.activeSessionsMu.Lock()
+	defer a.activeSessionsMu.Unlock()
+	a.activeSessions[id] = struct{}{}
+}
+
+// markSessionInactive records that id is no longer held by a
+// runSessionConsumer retry loop
+func (a *App) markSessionInactive(id string) {
+	a.activeSessionsMu.Lock()
+	defer a.activeSessionsMu.Unlock()
+	delete(a.activeSessions, id)
+}
+
+// isSessionActive reports whether id is currently held by a
+// runSessionConsumer retry loop
+func (a *App) isSessionActive(id string) bool {
+	a.activeSessionsMu.Lock()
+	defer a.activeSessionsMu.Unlock()
+	_, ok := a.activeSessions[id]
+	return ok
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsGaugeUpdateInterval is how often the gauges that reflect current
+// pipeline state (queue depth, semaphore occupancy, cursor age) are
+// refreshed
+const metricsGaugeUpdateInterval = 5 * time.Second
+
+// appMetrics holds every Prometheus collector exposed on /metrics
+type appMetrics struct {
+	eventsSentTotal   *prometheus.CounterVec
+	sendLatency       *prometheus.HistogramVec
+	endToEndLag       prometheus.Histogram
+	semaphoreOccupied prometheus.Gauge
+	semaphoreCapacity prometheus.Gauge
+	sessionQueueLen   prometheus.Gauge
+	pausedSessions    prometheus.Gauge
+	cursorAge         prometheus.Gauge
+}
+
+// newAppMetrics creates and registers the handler's metrics against reg
+func newAppMetrics(reg *prometheus.Registry) *appMetrics {
+	m := &appMetrics{
+		eventsSentTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "teleport_event_handler_events_sent_total",
+			Help: "Number of events successfully delivered, by sink and event type",
+		}, []string{"sink", "type"}),
+		sendLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "teleport_event_handler_sink_send_latency_seconds",
+			Help:    "Latency of a single event delivery to a sink",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"sink"}),
+		endToEndLag: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "teleport_event_handler_end_to_end_lag_seconds",
+			Help:    "Time between an event occurring in Teleport and being sent by the handler",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		}),
+		semaphoreOccupied: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "teleport_event_handler_semaphore_occupied",
+			Help: "Number of session ingestion slots currently in use",
+		}),
+		semaphoreCapacity: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "teleport_event_handler_semaphore_capacity",
+			Help: "Configured concurrency limit for session ingestion",
+		}),
+		sessionQueueLen: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "teleport_event_handler_session_queue_length",
+			Help: "Number of sessions waiting to be picked up by a consumer",
+		}),
+		pausedSessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "teleport_event_handler_paused_sessions",
+			Help: "Number of sessions persisted in state, pending or paused",
+		}),
+		cursorAge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "teleport_event_handler_cursor_age_seconds",
+			Help: "Time since the last successfully delivered event, from the audit log or a session stream",
+		}),
+	}
+
+	reg.MustRegister(
+		m.eventsSentTotal,
+		m.sendLatency,
+		m.endToEndLag,
+		m.semaphoreOccupied,
+		m.semaphoreCapacity,
+		m.sessionQueueLen,
+		m.pausedSessions,
+		m.cursorAge,
+	)
+
+	return m
+}
+
+// sinkName returns a stable metric label for a sink implementation
+func sinkName(sink EventSink) string {
+	switch sink.(type) {
+	case *FluentdSink:
+		return "fluentd"
+	case *WebhookSink:
+		return "webhook"
+	case *FileSink:
+		return "file"
+	default:
+		return "unknown"
+	}
+}
+
+// recordSinkSend updates the send latency histogram and, on success, the
+// events-sent counter for a single sink delivery.
+func (a *App) recordSinkSend(sink EventSink, e *TeleportEvent, d time.Duration, err error) {
+	name := sinkName(sink)
+	a.metrics.sendLatency.WithLabelValues(name).Observe(d.Seconds())
+	if err == nil {
+		a.metrics.eventsSentTotal.WithLabelValues(name, e.Type).Inc()
+	}
+}
+
+// recordSendSuccess marks the pipeline as healthy and observes end-to-end
+// lag for a successfully delivered event.
+func (a *App) recordSendSuccess(e *TeleportEvent) {
+	atomic.StoreInt64(&a.lastSendUnixNano, time.Now().UnixNano())
+	a.metrics.endToEndLag.Observe(time.Since(e.Time).Seconds())
+}
+
+// runMetricsServer serves /metrics and /healthz until ctx is done. A
+// freshly-forked SIGUSR2 child waits for the parent's handoff lock to
+// clear before binding, so the two processes never hold the listen
+// address at the same time.
+func (a *App) runMetricsServer(ctx context.Context) error {
+	if err := a.waitForUpgradeHandoff(ctx); err != nil {
+		return trace.Wrap(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(a.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", a.handleHealthz)
+
+	srv := &http.Server{Addr: a.config.MetricsAddr, Handler: mux}
+
+	a.metricsServerMu.Lock()
+	a.metricsServer = srv
+	a.metricsServerMu.Unlock()
+
+	a.metricsJob.SetReady(true)
+
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errCh <- trace.Wrap(err)
+			return
+		}
+		errCh <- nil
+	}()
+
+	go a.updateGauges(ctx)
+
+	select {
+	case <-ctx.Done():
+		a.stopMetricsServer()
+		return nil
+	case err := <-errCh:
+		return trace.Wrap(err)
+	}
+}
+
+// stopMetricsServer shuts down the metrics listener, if one is running. A
+// SIGUSR2 live upgrade calls this before releasing its handoff lock so the
+// forked child's bind on the same address never races this process's.
+func (a *App) stopMetricsServer() {
+	a.metricsServerMu.Lock()
+	srv := a.metricsServer
+	a.metricsServerMu.Unlock()
+
+	if srv == nil {
+		return
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+}
+
+// updateGauges periodically refreshes the gauges that reflect current
+// pipeline state rather than being updated event-by-event
+func (a *App) updateGauges(ctx context.Context) {
+	ticker := time.NewTicker(metricsGaugeUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.metrics.semaphoreOccupied.Set(float64(len(a.semaphore)))
+			a.metrics.semaphoreCapacity.Set(float64(cap(a.semaphore)))
+			a.metrics.sessionQueueLen.Set(float64(len(a.sessions)))
+
+			if a.state != nil {
+				if sessions, err := a.state.GetSessions(); err == nil {
+					a.metrics.pausedSessions.Set(float64(len(sessions)))
+				}
+			}
+
+			lastSend := atomic.LoadInt64(&a.lastSendUnixNano)
+			if lastSend > 0 {
+				a.metrics.cursorAge.Set(time.Since(time.Unix(0, lastSend)).Seconds())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleHealthz returns 503 while the pipeline's critical jobs are not
+// ready, or the last successful event delivery is older than
+// a.config.HealthzMaxLag, so k8s can restart a wedged instance.
+func (a *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&a.mainJobReady) == 0 || atomic.LoadInt32(&a.sessionConsumerReady) == 0 {
+		http.Error(w, "pipeline not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	if a.config.HealthzMaxLag > 0 {
+		lastSend := atomic.LoadInt64(&a.lastSendUnixNano)
+		if lastSend > 0 && time.Since(time.Unix(0, lastSend)) > a.config.HealthzMaxLag {
+			http.Error(w, fmt.Sprintf("no event delivered in the last %v", a.config.HealthzMaxLag), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
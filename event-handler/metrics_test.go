@@ -0,0 +1,67 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleHealthzNotReady(t *testing.T) {
+	a := &App{config: &StartCmdConfig{}}
+
+	w := httptest.NewRecorder()
+	a.handleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+
+	require.Equal(t, 503, w.Code)
+}
+
+func TestHandleHealthzReadyWithoutLagCheck(t *testing.T) {
+	a := &App{config: &StartCmdConfig{}}
+	atomic.StoreInt32(&a.mainJobReady, 1)
+	atomic.StoreInt32(&a.sessionConsumerReady, 1)
+
+	w := httptest.NewRecorder()
+	a.handleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+
+	require.Equal(t, 200, w.Code)
+}
+
+func TestHandleHealthzLagThreshold(t *testing.T) {
+	a := &App{config: &StartCmdConfig{HealthzMaxLag: time.Minute}}
+	atomic.StoreInt32(&a.mainJobReady, 1)
+	atomic.StoreInt32(&a.sessionConsumerReady, 1)
+
+	// No event sent yet: the lag check is skipped until the first delivery.
+	w := httptest.NewRecorder()
+	a.handleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	require.Equal(t, 200, w.Code)
+
+	atomic.StoreInt64(&a.lastSendUnixNano, time.Now().Add(-30*time.Second).UnixNano())
+	w = httptest.NewRecorder()
+	a.handleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	require.Equal(t, 200, w.Code)
+
+	atomic.StoreInt64(&a.lastSendUnixNano, time.Now().Add(-2*time.Minute).UnixNano())
+	w = httptest.NewRecorder()
+	a.handleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	require.Equal(t, 503, w.Code)
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifySessionStreamError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want sessionStreamOutcome
+	}{
+		{
+			name: "not found means missing recording",
+			err:  trace.NotFound("recording not found"),
+			want: sessionStreamMissingRecording,
+		},
+		{
+			name: "connection problem is retryable",
+			err:  trace.ConnectionProblem(nil, "connection reset"),
+			want: sessionStreamRetryable,
+		},
+		{
+			name: "anything else is permanent",
+			err:  trace.BadParameter("malformed stream"),
+			want: sessionStreamPermanent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, classifySessionStreamError(tt.err))
+		})
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/gravitational/teleport-plugins/lib/logger"
+	"github.com/gravitational/trace"
+)
+
+// liveUpgradeStorageDirEnv carries the storage dir path from a SIGUSR2
+// parent to the child it forks, so the child can find the lock file
+// without re-parsing CLI flags.
+const liveUpgradeStorageDirEnv = "TELEPORT_EVENT_HANDLER_STORAGE_DIR"
+
+// reloadLockFile coordinates a SIGUSR2 parent/child handoff: the child
+// will not start polling until the parent has removed it, which the parent
+// only does once its semaphore has fully drained.
+const reloadLockFile = "reload.lock"
+
+// handleSignals watches for SIGHUP (graceful reload) and SIGUSR2 (live
+// fork-exec upgrade), leaving SIGTERM to the existing Terminate() path so
+// an immediate shutdown is unaffected. It keeps servicing signals for the
+// life of the process, so a failed live upgrade (which explicitly leaves
+// this process running) can still be retried on a later SIGHUP/SIGUSR2
+// instead of falling back to Go's default signal disposition.
+func (a *App) handleSignals(ctx context.Context) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGUSR2)
+	defer signal.Stop(sigCh)
+
+	log := logger.Get(ctx)
+
+	for {
+		select {
+		case sig := <-sigCh:
+			switch sig {
+			case syscall.SIGHUP:
+				log.Info("Received SIGHUP, starting graceful reload")
+				a.gracefulReload(ctx)
+			case syscall.SIGUSR2:
+				log.Info("Received SIGUSR2, starting live upgrade")
+				if err := a.liveUpgrade(ctx); err != nil {
+					log.WithField("err", err).Error("Live upgrade failed, continuing to run under the current process")
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// gracefulReload stops accepting new sessions, waits for in-flight ones to
+// drain (each consumeSession checkpoints SetSessionIndex naturally as it
+// goes, so none of that progress is lost), flushes state and sinks, then
+// exits so a supervisor can start the replacement.
+func (a *App) gracefulReload(ctx context.Context) {
+	log := logger.Get(ctx)
+
+	a.stopAcceptingSessions()
+
+	deadline := time.NewTimer(a.config.GracefulShutdownTimeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for len(a.semaphore) > 0 {
+		select {
+		case <-ticker.C:
+			continue
+		case <-deadline.C:
+			log.WithField("pending", len(a.semaphore)).Warning("Graceful shutdown timeout exceeded, exiting with sessions still in flight")
+		}
+		break
+	}
+
+	a.flushForShutdown(ctx)
+	os.Exit(0)
+}
+
+// liveUpgrade forks a replacement process that inherits the storage dir,
+// stops accepting new sessions and hands off as soon as the semaphore has
+// fully drained: the child blocks on the lock file until this process,
+// after flushing, removes it and exits. It does not wait for a termination
+// signal of its own before handing off.
+func (a *App) liveUpgrade(ctx context.Context) error {
+	log := logger.Get(ctx)
+
+	lockPath := filepath.Join(a.config.StorageDir, reloadLockFile)
+	if err := os.WriteFile(lockPath, []byte{}, 0o640); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+
+	child := exec.Command(executable, os.Args[1:]...)
+	child.Env = append(os.Environ(), liveUpgradeStorageDirEnv+"="+a.config.StorageDir)
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	if err := child.Start(); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+
+	log.WithField("pid", child.Process.Pid).Info("Forked replacement process, draining before handoff")
+
+	a.stopAcceptingSessions()
+
+	for len(a.semaphore) > 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	a.flushForShutdown(ctx)
+
+	if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+		log.WithField("err", err).Error("Failed to remove reload lock file")
+	}
+
+	os.Exit(0)
+	return nil
+}
+
+// waitForUpgradeHandoff blocks a freshly-forked child until the lock file
+// left by its parent is removed, signalling the parent's semaphore has
+// fully drained.
+func (a *App) waitForUpgradeHandoff(ctx context.Context) error {
+	storageDir := os.Getenv(liveUpgradeStorageDirEnv)
+	if storageDir == "" {
+		return nil
+	}
+
+	lockPath := filepath.Join(storageDir, reloadLockFile)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if _, err := os.Stat(lockPath); os.IsNotExist(err) {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+			continue
+		case <-ctx.Done():
+			return trace.Wrap(ctx.Err())
+		}
+	}
+}
+
+// stopAcceptingSessions closes a.stopAccepting exactly once, causing
+// runSessionConsumer to stop pulling new work off a.sessions while letting
+// already-dispatched consumeSession goroutines run to completion.
+func (a *App) stopAcceptingSessions() {
+	a.stopOnce.Do(func() {
+		close(a.stopAccepting)
+	})
+}
+
+// flushForShutdown persists the window checkpoint, closes every sink and
+// stops the metrics listener (if any), logging but not failing on errors
+// since the process is exiting either way. Stopping the metrics listener
+// here, before a SIGUSR2 live upgrade removes its handoff lock, is what
+// keeps the forked child's bind on the same address from racing this
+// process's.
+func (a *App) flushForShutdown(ctx context.Context) {
+	log := logger.Get(ctx)
+
+	if err := a.flushWindowTime(); err != nil {
+		log.WithField("err", err).Error("Failed to flush window checkpoint")
+	}
+
+	for _, sink := range a.sinks {
+		if err := sink.Close(); err != nil {
+			log.WithField("err", err).Error("Failed to close event sink")
+		}
+	}
+
+	a.stopMetricsServer()
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+// EventSink is a destination events can be delivered to. Implementations
+// must be safe for concurrent use, since sendEvent fans out to every sink
+// in parallel.
+type EventSink interface {
+	// Send delivers a single event. category identifies the stream the
+	// event came from ("audit" for the main audit log, "session" for
+	// session recording events) so sinks that care can route or label
+	// accordingly.
+	Send(ctx context.Context, category string, e *TeleportEvent) error
+
+	// Close releases any resources held by the sink.
+	Close() error
+}
+
+// SinkType identifies a configured EventSink implementation
+type SinkType string
+
+const (
+	// SinkTypeWebhook is a generic HTTP webhook sink
+	SinkTypeWebhook SinkType = "webhook"
+	// SinkTypeFile is a local rotating JSONL sink
+	SinkTypeFile SinkType = "file"
+)
+
+// SinkConfig configures an additional EventSink beyond the always-present
+// fluentd sink
+type SinkConfig struct {
+	// Type selects the sink implementation
+	Type SinkType
+
+	// URL is the destination URL for SinkTypeWebhook
+	URL string
+	// HMACSecret signs the webhook payload when set
+	HMACSecret string
+
+	// Dir is the destination directory for SinkTypeFile
+	Dir string
+	// MaxBytes rotates the active file once it exceeds this size
+	MaxBytes int64
+
+	// SkipTypes holds the event types this sink should not receive
+	SkipTypes map[string]struct{}
+}
+
+// FluentdSink adapts the existing FluentdClient to the EventSink interface
+type FluentdSink struct {
+	client     *FluentdClient
+	mainURL    string
+	sessionURL string
+	// skipSessionTypes holds the event types to withhold from the
+	// per-session fluentd stream only; the main audit log stream is never
+	// filtered by it.
+	skipSessionTypes map[string]struct{}
+}
+
+// NewFluentdSink creates a sink that forwards events to fluentd
+func NewFluentdSink(client *FluentdClient, mainURL, sessionURL string, skipSessionTypes map[string]struct{}) *FluentdSink {
+	return &FluentdSink{client: client, mainURL: mainURL, sessionURL: sessionURL, skipSessionTypes: skipSessionTypes}
+}
+
+// Send implements EventSink
+func (s *FluentdSink) Send(ctx context.Context, category string, e *TeleportEvent) error {
+	if category == "session" {
+		if _, ok := s.skipSessionTypes[e.Type]; ok {
+			return nil
+		}
+	}
+
+	url := s.mainURL
+	if e.SessionID != "" {
+		url = s.sessionURL + "." + e.SessionID + ".log"
+	}
+
+	return trace.Wrap(s.client.Send(ctx, url, e.Event))
+}
+
+// Close implements EventSink
+func (s *FluentdSink) Close() error {
+	return trace.Wrap(s.client.Close())
+}
+
+// WebhookSink delivers events as HMAC-signed JSON POST requests, for
+// consumers that don't speak the fluentd forward protocol.
+type WebhookSink struct {
+	url       string
+	secret    []byte
+	client    *http.Client
+	skipTypes map[string]struct{}
+}
+
+// NewWebhookSink creates a webhook sink. secret may be empty, in which case
+// requests are sent unsigned.
+func NewWebhookSink(url string, secret []byte, skipTypes map[string]struct{}) *WebhookSink {
+	return &WebhookSink{
+		url:       url,
+		secret:    secret,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		skipTypes: skipTypes,
+	}
+}
+
+// Send implements EventSink
+func (s *WebhookSink) Send(ctx context.Context, category string, e *TeleportEvent) error {
+	if _, ok := s.skipTypes[e.Type]; ok {
+		return nil
+	}
+
+	body, err := json.Marshal(e.Event)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Teleport-Event-Category", category)
+
+	if len(s.secret) > 0 {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(body)
+		req.Header.Set("X-Teleport-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return trace.ConnectionProblem(err, "failed to deliver event to webhook sink %v", s.url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return trace.ConnectionProblem(nil, "webhook sink %v returned %v", s.url, resp.StatusCode)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return trace.BadParameter("webhook sink %v rejected event with %v", s.url, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Close implements EventSink
+func (s *WebhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// FileSink writes events as newline-delimited JSON to a rotating set of
+// files, for air-gapped audit archival.
+type FileSink struct {
+	mu        sync.Mutex
+	dir       string
+	maxBytes  int64
+	skipTypes map[string]struct{}
+
+	file *os.File
+	size int64
+}
+
+// NewFileSink creates a file sink rooted at dir, rotating once the active
+// file exceeds maxBytes.
+func NewFileSink(dir string, maxBytes int64, skipTypes map[string]struct{}) (*FileSink, error) {
+	if maxBytes <= 0 {
+		return nil, trace.BadParameter("file sink max bytes must be positive, got %v", maxBytes)
+	}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+
+	return &FileSink{dir: dir, maxBytes: maxBytes, skipTypes: skipTypes}, nil
+}
+
+// Send implements EventSink
+func (s *FileSink) Send(ctx context.Context, category string, e *TeleportEvent) error {
+	if _, ok := s.skipTypes[e.Type]; ok {
+		return nil
+	}
+
+	data, err := json.Marshal(e.Event)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.rotateIfNeeded(len(data)); err != nil {
+		return trace.Wrap(err)
+	}
+
+	n, err := s.file.Write(data)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	s.size += int64(n)
+
+	return nil
+}
+
+// rotateIfNeeded opens a new file if there is none yet, or the active one
+// would exceed maxBytes with the next write. Caller must hold s.mu.
+func (s *FileSink) rotateIfNeeded(nextWriteSize int) error {
+	if s.file != nil && s.size+int64(nextWriteSize) <= s.maxBytes {
+		return nil
+	}
+
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return trace.ConvertSystemError(err)
+		}
+	}
+
+	name := fmt.Sprintf("events-%v.jsonl", time.Now().UTC().Format("20060102T150405.000000000"))
+	f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+
+	s.file = f
+	s.size = 0
+
+	return nil
+}
+
+// Close implements EventSink
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	return trace.ConvertSystemError(s.file.Close())
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFileSinkRejectsNonPositiveMaxBytes(t *testing.T) {
+	_, err := NewFileSink(t.TempDir(), 0, nil)
+	require.Error(t, err)
+
+	_, err = NewFileSink(t.TempDir(), -1, nil)
+	require.Error(t, err)
+}
+
+func TestFileSinkRotateIfNeeded(t *testing.T) {
+	dir := t.TempDir()
+
+	sink, err := NewFileSink(dir, 10, nil)
+	require.NoError(t, err)
+
+	// No file open yet, so the first write always rotates.
+	require.NoError(t, sink.rotateIfNeeded(5))
+	first := sink.file
+	require.NotNil(t, first)
+	require.Zero(t, sink.size)
+
+	// Fits under the limit: no rotation.
+	sink.size = 5
+	require.NoError(t, sink.rotateIfNeeded(3))
+	require.Same(t, first, sink.file)
+
+	// Would exceed the limit: rotates to a new file.
+	sink.size = 8
+	require.NoError(t, sink.rotateIfNeeded(5))
+	require.NotSame(t, first, sink.file)
+	require.Zero(t, sink.size)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestFileSinkSendSkipsConfiguredTypes(t *testing.T) {
+	dir := t.TempDir()
+
+	sink, err := NewFileSink(dir, 1024, map[string]struct{}{"skip.me": {}})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.Send(context.Background(), "audit", &TeleportEvent{Type: "skip.me"}))
+	require.Nil(t, sink.file)
+
+	require.NoError(t, sink.Send(context.Background(), "audit", &TeleportEvent{Type: "keep.me"}))
+	require.NotNil(t, sink.file)
+}
+
+func TestWebhookSinkSignsPayloadWithHMAC(t *testing.T) {
+	secret := []byte("super-secret")
+
+	var gotBody []byte
+	var gotSignature string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSignature = r.Header.Get("X-Teleport-Signature")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink := NewWebhookSink(srv.URL, secret, nil)
+	defer sink.Close()
+
+	err := sink.Send(context.Background(), "audit", &TeleportEvent{Type: "test.event"})
+	require.NoError(t, err)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(gotBody)
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	require.Equal(t, want, gotSignature)
+}
+
+func TestWebhookSinkUnsignedWithoutSecret(t *testing.T) {
+	var gotSignature string
+	sawHeader := false
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature, sawHeader = r.Header.Get("X-Teleport-Signature"), r.Header.Get("X-Teleport-Signature") != ""
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink := NewWebhookSink(srv.URL, nil, nil)
+	defer sink.Close()
+
+	err := sink.Send(context.Background(), "audit", &TeleportEvent{Type: "test.event"})
+	require.NoError(t, err)
+	require.False(t, sawHeader)
+	require.Empty(t, gotSignature)
+}
+
+func TestWebhookSinkServerErrorIsConnectionProblem(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	sink := NewWebhookSink(srv.URL, nil, nil)
+	defer sink.Close()
+
+	err := sink.Send(context.Background(), "audit", &TeleportEvent{Type: "test.event"})
+	require.Error(t, err)
+	require.True(t, trace.IsConnectionProblem(err))
+}
+
+func TestFileSinkRotatesIntoNewDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "audit")
+	sink, err := NewFileSink(dir, 1024, nil)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.Send(context.Background(), "audit", &TeleportEvent{Type: "test.event"}))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
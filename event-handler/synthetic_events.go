@@ -0,0 +1,30 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "time"
+
+// NewMissingRecordingEvent builds the synthetic event emitted in place of a
+// session's audit events when its recording could not be found.
+func NewMissingRecordingEvent(sessionID string) *TeleportEvent {
+	return &TeleportEvent{
+		ID:        sessionID + "-recording-missing",
+		Type:      missingRecordingEventType,
+		Time:      time.Now().UTC(),
+		SessionID: sessionID,
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2015-2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeResumeWindowTime(t *testing.T) {
+	configuredStart := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("no checkpoint yet uses configured start", func(t *testing.T) {
+		got := computeResumeWindowTime(nil, configuredStart)
+		require.True(t, got.Equal(configuredStart))
+	})
+
+	t.Run("resumes overlap before the checkpoint", func(t *testing.T) {
+		checkpoint := configuredStart.Add(time.Hour)
+		got := computeResumeWindowTime(&checkpoint, configuredStart)
+		require.True(t, got.Equal(checkpoint.Add(-windowOverlap)))
+	})
+
+	t.Run("clamps to configured start when overlap predates it", func(t *testing.T) {
+		checkpoint := configuredStart.Add(windowOverlap / 2)
+		got := computeResumeWindowTime(&checkpoint, configuredStart)
+		require.True(t, got.Equal(configuredStart))
+	})
+
+	t.Run("clamps to configured start when checkpoint predates it", func(t *testing.T) {
+		checkpoint := configuredStart.Add(-24 * time.Hour)
+		got := computeResumeWindowTime(&checkpoint, configuredStart)
+		require.True(t, got.Equal(configuredStart))
+	})
+}